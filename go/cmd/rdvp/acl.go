@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	libp2p_connmgr "github.com/libp2p/go-libp2p-core/connmgr"
+	libp2p_control "github.com/libp2p/go-libp2p-core/control"
+	libp2p_network "github.com/libp2p/go-libp2p-core/network"
+	libp2p_peer "github.com/libp2p/go-libp2p-core/peer"
+	libp2p_rp "github.com/libp2p/go-libp2p-rendezvous"
+
+	ma "github.com/multiformats/go-multiaddr"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+	"gopkg.in/yaml.v2"
+)
+
+// ACLRule maps a namespace glob (as matched by filepath.Match) to the peers
+// allowed, or denied, to register in namespaces it matches.
+type ACLRule struct {
+	Namespace string   `yaml:"namespace" json:"namespace"`
+	Allow     []string `yaml:"allow,omitempty" json:"allow,omitempty"`
+	Deny      []string `yaml:"deny,omitempty" json:"deny,omitempty"`
+}
+
+// ACL is the parsed contents of an `-acl` file: an ordered list of rules,
+// first matching rule wins; a namespace matching no rule is allowed.
+type ACL struct {
+	Rules []ACLRule `yaml:"rules" json:"rules"`
+}
+
+// loadACL reads an ACL from path, as YAML or, if the extension is .json, as
+// JSON.
+func loadACL(path string) (*ACL, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errcode.TODO.Wrap(err)
+	}
+
+	var acl ACL
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(raw, &acl)
+	} else {
+		err = yaml.Unmarshal(raw, &acl)
+	}
+	if err != nil {
+		return nil, errcode.TODO.Wrap(err)
+	}
+
+	return &acl, nil
+}
+
+// allowed reports whether peer p may register in namespace ns.
+func (a *ACL) allowed(ns string, p libp2p_peer.ID) bool {
+	id := p.Pretty()
+
+	for _, rule := range a.Rules {
+		matched, err := filepath.Match(rule.Namespace, ns)
+		if err != nil || !matched {
+			continue
+		}
+
+		for _, denied := range rule.Deny {
+			if denied == id {
+				return false
+			}
+		}
+		if len(rule.Allow) == 0 {
+			return true
+		}
+		for _, allowed := range rule.Allow {
+			if allowed == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// aclDB wraps a rendezvous DB and enforces the loaded ACL, so operators can
+// keep community namespaces from being squatted on:
+//
+//   - Register rejects peers not allowed into a namespace outright. This is
+//     also the enforcement point for replicated writes coming from cluster
+//     peers (see newCluster in main.go, which is handed the ACL-wrapped DB).
+//   - Discover filters out registrations the ACL would deny, which in
+//     practice only matters for registrations that reached the store some
+//     other way than through this Register (e.g. a cluster peer running a
+//     looser ACL). Store.Discover has no notion of the *requesting* peer
+//     (that would require rdvp's wire protocol itself to carry it), so this
+//     cannot gate Discover by caller the way Register gates by registrant;
+//     that half of the enforcement lives at the connection layer instead,
+//     see aclConnGater below.
+type aclDB struct {
+	libp2p_rp.DB
+
+	acl *ACL
+}
+
+func newACLDB(db libp2p_rp.DB, acl *ACL) *aclDB {
+	return &aclDB{DB: db, acl: acl}
+}
+
+func (db *aclDB) Register(ns string, pi libp2p_peer.AddrInfo, ttl int) (string, error) {
+	if !db.acl.allowed(ns, pi.ID) {
+		return "", errcode.TODO.Wrap(fmt.Errorf("rdvp: peer %s is not allowed to register in namespace %q", pi.ID.Pretty(), ns))
+	}
+	return db.DB.Register(ns, pi, ttl)
+}
+
+func (db *aclDB) Discover(ns string, cookie []byte, limit int) ([]libp2p_rp.Registration, []byte, error) {
+	regs, newCookie, err := db.DB.Discover(ns, cookie, limit)
+	if err != nil {
+		return regs, newCookie, err
+	}
+
+	filtered := regs[:0]
+	for _, reg := range regs {
+		if db.acl.allowed(ns, reg.Peer.ID) {
+			filtered = append(filtered, reg)
+		}
+	}
+	return filtered, newCookie, nil
+}
+
+// aclConnGater refuses the underlying libp2p connection for any peer denied
+// by a rule matching the wildcard "*" namespace, i.e. an operator-wide
+// blocklist. Unlike aclDB.Discover, this runs before any rendezvous protocol
+// stream is accepted, so the remote peer ID is known directly from the
+// connection rather than filtered out of a result set after the fact — it's
+// enforcement against the caller, not just the registrant. It cannot gate a
+// single namespace's Discover by caller (that namespace is only known once
+// the rendezvous wire message is decoded, inside the vendored
+// go-libp2p-rendezvous service), so a peer allowed into at least one
+// namespace can still Discover a namespace it's individually denied in; only
+// a peer denied everywhere is stopped here.
+type aclConnGater struct {
+	acl *ACL
+}
+
+func newACLConnGater(acl *ACL) *aclConnGater {
+	return &aclConnGater{acl: acl}
+}
+
+func (g *aclConnGater) InterceptPeerDial(p libp2p_peer.ID) bool { return true }
+
+func (g *aclConnGater) InterceptAddrDial(p libp2p_peer.ID, addr ma.Multiaddr) bool { return true }
+
+func (g *aclConnGater) InterceptAccept(addrs libp2p_network.ConnMultiaddrs) bool { return true }
+
+func (g *aclConnGater) InterceptSecured(dir libp2p_network.Direction, p libp2p_peer.ID, addrs libp2p_network.ConnMultiaddrs) bool {
+	return g.acl.allowed("*", p)
+}
+
+func (g *aclConnGater) InterceptUpgraded(conn libp2p_network.Conn) (bool, libp2p_control.DisconnectReason) {
+	return true, 0
+}
+
+var _ libp2p_connmgr.ConnectionGater = (*aclConnGater)(nil)
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+
+	libp2p_peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestACLAllowedDefaultsToAllow(t *testing.T) {
+	acl := &ACL{}
+	peer1 := libp2p_peer.ID("peer1")
+
+	if !acl.allowed("any-namespace", peer1) {
+		t.Fatal("a namespace matching no rule should be allowed")
+	}
+}
+
+func TestACLAllowedDenyList(t *testing.T) {
+	peer1, peer2 := libp2p_peer.ID("peer1"), libp2p_peer.ID("peer2")
+	acl := &ACL{Rules: []ACLRule{
+		{Namespace: "restricted", Deny: []string{peer1.Pretty()}},
+	}}
+
+	if acl.allowed("restricted", peer1) {
+		t.Fatal("denied peer should not be allowed")
+	}
+	if !acl.allowed("restricted", peer2) {
+		t.Fatal("peer not on the deny list should be allowed")
+	}
+}
+
+func TestACLAllowedAllowListIsExclusive(t *testing.T) {
+	peer1, peer2 := libp2p_peer.ID("peer1"), libp2p_peer.ID("peer2")
+	acl := &ACL{Rules: []ACLRule{
+		{Namespace: "private", Allow: []string{peer1.Pretty()}},
+	}}
+
+	if !acl.allowed("private", peer1) {
+		t.Fatal("peer on the allow list should be allowed")
+	}
+	if acl.allowed("private", peer2) {
+		t.Fatal("a non-empty allow list should exclude peers not on it")
+	}
+}
+
+func TestACLAllowedFirstMatchingRuleWins(t *testing.T) {
+	peer1 := libp2p_peer.ID("peer1")
+	acl := &ACL{Rules: []ACLRule{
+		{Namespace: "ns-*", Deny: []string{peer1.Pretty()}},
+		{Namespace: "ns-special", Allow: []string{peer1.Pretty()}},
+	}}
+
+	if acl.allowed("ns-special", peer1) {
+		t.Fatal("the first matching rule (ns-*, deny peer1) should win over the later, more specific rule")
+	}
+}
+
+func TestACLAllowedGlobMatching(t *testing.T) {
+	peer1 := libp2p_peer.ID("peer1")
+	acl := &ACL{Rules: []ACLRule{
+		{Namespace: "team-*", Deny: []string{peer1.Pretty()}},
+	}}
+
+	if acl.allowed("team-eng", peer1) {
+		t.Fatal("glob pattern team-* should match team-eng")
+	}
+	if !acl.allowed("other", peer1) {
+		t.Fatal("glob pattern team-* should not match an unrelated namespace")
+	}
+}
+
+func TestACLConnGaterBlocksWildcardDeny(t *testing.T) {
+	peer1, peer2 := libp2p_peer.ID("peer1"), libp2p_peer.ID("peer2")
+	gater := newACLConnGater(&ACL{Rules: []ACLRule{
+		{Namespace: "*", Deny: []string{peer1.Pretty()}},
+	}})
+
+	if gater.InterceptSecured(0, peer1, nil) {
+		t.Fatal("a peer denied by a wildcard rule should have its connection refused")
+	}
+	if !gater.InterceptSecured(0, peer2, nil) {
+		t.Fatal("a peer not on the wildcard deny list should be allowed to connect")
+	}
+}
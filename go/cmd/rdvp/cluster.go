@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	libp2p_host "github.com/libp2p/go-libp2p-core/host"
+	libp2p_network "github.com/libp2p/go-libp2p-core/network"
+	libp2p_peer "github.com/libp2p/go-libp2p-core/peer"
+	libp2p_protocol "github.com/libp2p/go-libp2p-core/protocol"
+	libp2p_rp "github.com/libp2p/go-libp2p-rendezvous"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"go.uber.org/zap"
+)
+
+// clusterProtocolID is the libp2p protocol rdvp instances use to replicate
+// registrations with one another.
+const clusterProtocolID = libp2p_protocol.ID("/rdvp/replicate/1.0.0")
+
+// clusterRecord is the CRDT unit replicated between rdvp instances: a
+// last-writer-wins register keyed by (namespace, peer), using the
+// registration's expiry as both the LWW timestamp and the tombstone timer.
+type clusterRecord struct {
+	Namespace string   `json:"ns"`
+	PeerID    string   `json:"peer_id"`
+	Addrs     []string `json:"addrs,omitempty"`
+	Expire    int64    `json:"expire"`
+	Tombstone bool     `json:"tombstone,omitempty"`
+}
+
+func (r clusterRecord) key() string { return r.Namespace + "/" + r.PeerID }
+
+// clusterMsg is the wire message exchanged over clusterProtocolID streams.
+// "push" is fire-and-forget; "summary-req" and "pull-req" always get a
+// "summary-resp"/"pull-resp" written back on the same stream before it
+// closes.
+type clusterMsg struct {
+	Type       string            `json:"type"` // "push", "summary-req", "summary-resp", "pull-req", "pull-resp"
+	Records    []clusterRecord   `json:"records,omitempty"`
+	Summary    map[string]string `json:"summary,omitempty"`    // namespace -> hash of its sorted records
+	Namespaces []string          `json:"namespaces,omitempty"` // requested by "pull-req"
+}
+
+// cluster replicates rdvp registrations across a set of peer rdvp instances,
+// so a Discover on any member returns the union of all of their
+// registrations. Reconciliation runs once at startup and then every 30
+// seconds (see reconcileAll), comparing per-namespace Merkle-style digests and
+// only exchanging the namespaces that differ; a peer connecting does not by
+// itself trigger a reconciliation.
+type cluster struct {
+	host   libp2p_host.Host
+	db     libp2p_rp.DB
+	logger *zap.Logger
+	peers  []libp2p_peer.AddrInfo
+
+	mu      sync.Mutex
+	records map[string]clusterRecord
+}
+
+func newCluster(host libp2p_host.Host, db libp2p_rp.DB, peers []libp2p_peer.AddrInfo, logger *zap.Logger) *cluster {
+	return &cluster{
+		host:    host,
+		db:      db,
+		logger:  logger,
+		peers:   peers,
+		records: make(map[string]clusterRecord),
+	}
+}
+
+// parsePeerAddrs turns a comma-separated list of `/.../p2p/<id>` multiaddrs
+// (as passed to `-peers`) into AddrInfos.
+func parsePeerAddrs(addrs ...string) ([]libp2p_peer.AddrInfo, error) {
+	infos := make([]libp2p_peer.AddrInfo, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr == "" {
+			continue
+		}
+		maddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		pi, err := libp2p_peer.AddrInfoFromP2pAddr(maddr)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, *pi)
+	}
+	return infos, nil
+}
+
+// recordLocal is called whenever a Register/Unregister happens on this node;
+// it stores the record, broadcasts it to connected cluster peers and applies
+// the resolution to itself, so it participates in its own LWW resolution.
+func (c *cluster) recordLocal(rec clusterRecord) {
+	if c.merge(rec) {
+		c.broadcast(rec)
+	}
+}
+
+// merge applies rec under last-writer-wins (highest Expire wins) and reports
+// whether it was actually applied (i.e. it wasn't stale).
+func (c *cluster) merge(rec clusterRecord) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if existing, ok := c.records[rec.key()]; ok && existing.Expire >= rec.Expire {
+		return false
+	}
+	c.records[rec.key()] = rec
+	return true
+}
+
+// applyRemote merges a record received from a peer into both the CRDT table
+// and the local rendezvous DB.
+func (c *cluster) applyRemote(rec clusterRecord) {
+	if !c.merge(rec) {
+		return
+	}
+
+	pid, err := libp2p_peer.Decode(rec.PeerID)
+	if err != nil {
+		return
+	}
+
+	if rec.Tombstone {
+		_ = c.db.Unregister(rec.Namespace, pid)
+		return
+	}
+
+	addrs := make([]ma.Multiaddr, 0, len(rec.Addrs))
+	for _, a := range rec.Addrs {
+		if maddr, err := ma.NewMultiaddr(a); err == nil {
+			addrs = append(addrs, maddr)
+		}
+	}
+	ttl := int(rec.Expire - time.Now().Unix())
+	if ttl <= 0 {
+		return
+	}
+	_, _ = c.db.Register(rec.Namespace, libp2p_peer.AddrInfo{ID: pid, Addrs: addrs}, ttl)
+}
+
+func (c *cluster) namespaceDigests() map[string]string {
+	c.mu.Lock()
+	byNamespace := map[string][]string{}
+	for _, rec := range c.records {
+		byNamespace[rec.Namespace] = append(byNamespace[rec.Namespace], fmt.Sprintf("%s:%d:%v", rec.PeerID, rec.Expire, rec.Tombstone))
+	}
+	c.mu.Unlock()
+
+	digests := make(map[string]string, len(byNamespace))
+	for ns, entries := range byNamespace {
+		sort.Strings(entries)
+		h := sha256.New()
+		for _, e := range entries {
+			_, _ = h.Write([]byte(e))
+		}
+		digests[ns] = hex.EncodeToString(h.Sum(nil))
+	}
+	return digests
+}
+
+func (c *cluster) recordsForNamespaces(namespaces map[string]struct{}) []clusterRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	recs := make([]clusterRecord, 0)
+	for _, rec := range c.records {
+		if _, ok := namespaces[rec.Namespace]; ok {
+			recs = append(recs, rec)
+		}
+	}
+	return recs
+}
+
+func (c *cluster) broadcast(recs ...clusterRecord) {
+	msg := clusterMsg{Type: "push", Records: recs}
+	for _, pi := range c.peers {
+		go c.send(pi.ID, msg)
+	}
+}
+
+// send opens a fire-and-forget stream to peer `to` and writes msg; no reply
+// is read. Used for "push".
+func (c *cluster) send(to libp2p_peer.ID, msg clusterMsg) {
+	s, err := c.host.NewStream(context.Background(), to, clusterProtocolID)
+	if err != nil {
+		return
+	}
+	defer s.Close()
+
+	_ = json.NewEncoder(s).Encode(msg)
+}
+
+// requestSummary opens a stream to peer `to`, sends a summary-req and reads
+// back its summary-resp reply on that same stream.
+func (c *cluster) requestSummary(to libp2p_peer.ID) (map[string]string, error) {
+	s, err := c.host.NewStream(context.Background(), to, clusterProtocolID)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	if err := json.NewEncoder(s).Encode(clusterMsg{Type: "summary-req"}); err != nil {
+		return nil, err
+	}
+
+	var resp clusterMsg
+	if err := json.NewDecoder(s).Decode(&resp); err != nil {
+		return nil, err
+	}
+	return resp.Summary, nil
+}
+
+// requestRecords opens a stream to peer `to`, asks for its records in
+// namespaces and reads back the pull-resp reply on that same stream.
+func (c *cluster) requestRecords(to libp2p_peer.ID, namespaces []string) ([]clusterRecord, error) {
+	s, err := c.host.NewStream(context.Background(), to, clusterProtocolID)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	if err := json.NewEncoder(s).Encode(clusterMsg{Type: "pull-req", Namespaces: namespaces}); err != nil {
+		return nil, err
+	}
+
+	var resp clusterMsg
+	if err := json.NewDecoder(s).Decode(&resp); err != nil {
+		return nil, err
+	}
+	return resp.Records, nil
+}
+
+// handleStream is the clusterProtocolID stream handler: it reads a single
+// message and, for "summary-req"/"pull-req", writes the reply back on the
+// same stream before returning.
+func (c *cluster) handleStream(s libp2p_network.Stream) {
+	defer s.Close()
+
+	var msg clusterMsg
+	if err := json.NewDecoder(s).Decode(&msg); err != nil {
+		return
+	}
+
+	switch msg.Type {
+	case "push":
+		for _, rec := range msg.Records {
+			c.applyRemote(rec)
+		}
+
+	case "summary-req":
+		_ = json.NewEncoder(s).Encode(clusterMsg{Type: "summary-resp", Summary: c.namespaceDigests()})
+
+	case "pull-req":
+		namespaces := make(map[string]struct{}, len(msg.Namespaces))
+		for _, ns := range msg.Namespaces {
+			namespaces[ns] = struct{}{}
+		}
+		_ = json.NewEncoder(s).Encode(clusterMsg{Type: "pull-resp", Records: c.recordsForNamespaces(namespaces)})
+	}
+}
+
+// reconcileAll periodically exchanges namespace digests with every
+// configured peer, pulling the namespaces whose hash differs. This is what
+// lets a node that was offline catch up on reconnect instead of only seeing
+// whatever gets broadcast live afterwards.
+func (c *cluster) reconcileAll(ctx context.Context) error {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	request := func() {
+		for _, pi := range c.peers {
+			pi := pi
+			go func() {
+				summary, err := c.requestSummary(pi.ID)
+				if err != nil {
+					return
+				}
+				c.reconcile(pi.ID, summary)
+			}()
+		}
+	}
+
+	request()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			request()
+		}
+	}
+}
+
+// reconcile compares a peer's namespace digests against ours and, for every
+// namespace that differs, both pushes our view of it to the peer and pulls
+// the peer's view of it back, so both sides converge via LWW regardless of
+// which one is missing data. Pulling is what lets a node that just came back
+// online catch up even if no peer's own reconcile loop happens to push to it
+// first.
+func (c *cluster) reconcile(from libp2p_peer.ID, remote map[string]string) {
+	local := c.namespaceDigests()
+
+	diverged := map[string]struct{}{}
+	for ns, hash := range remote {
+		if local[ns] != hash {
+			diverged[ns] = struct{}{}
+		}
+	}
+	for ns, hash := range local {
+		if remote[ns] != hash {
+			diverged[ns] = struct{}{}
+		}
+	}
+	if len(diverged) == 0 {
+		return
+	}
+
+	c.send(from, clusterMsg{Type: "push", Records: c.recordsForNamespaces(diverged)})
+
+	namespaces := make([]string, 0, len(diverged))
+	for ns := range diverged {
+		namespaces = append(namespaces, ns)
+	}
+	recs, err := c.requestRecords(from, namespaces)
+	if err != nil {
+		return
+	}
+	for _, rec := range recs {
+		c.applyRemote(rec)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+func newTestCluster() *cluster {
+	return &cluster{records: make(map[string]clusterRecord)}
+}
+
+func TestClusterMergeLastWriterWins(t *testing.T) {
+	c := newTestCluster()
+
+	rec := clusterRecord{Namespace: "ns", PeerID: "peer", Expire: 100}
+	if !c.merge(rec) {
+		t.Fatal("merge of a new record should apply")
+	}
+
+	stale := clusterRecord{Namespace: "ns", PeerID: "peer", Expire: 50}
+	if c.merge(stale) {
+		t.Fatal("merge of a record with a lower Expire should not apply")
+	}
+	if c.records[rec.key()].Expire != 100 {
+		t.Fatalf("stale merge must not overwrite the existing record, got Expire=%d", c.records[rec.key()].Expire)
+	}
+
+	newer := clusterRecord{Namespace: "ns", PeerID: "peer", Expire: 200}
+	if !c.merge(newer) {
+		t.Fatal("merge of a record with a higher Expire should apply")
+	}
+	if c.records[rec.key()].Expire != 200 {
+		t.Fatalf("expected Expire=200 after merging newer record, got %d", c.records[rec.key()].Expire)
+	}
+}
+
+func TestClusterNamespaceDigestsMatchOnEqualState(t *testing.T) {
+	a := newTestCluster()
+	b := newTestCluster()
+
+	recs := []clusterRecord{
+		{Namespace: "ns1", PeerID: "peer1", Expire: 100},
+		{Namespace: "ns1", PeerID: "peer2", Expire: 150},
+		{Namespace: "ns2", PeerID: "peer1", Expire: 100},
+	}
+	for _, rec := range recs {
+		a.merge(rec)
+		b.merge(rec)
+	}
+
+	da, db := a.namespaceDigests(), b.namespaceDigests()
+	if len(da) != 2 {
+		t.Fatalf("expected digests for 2 namespaces, got %d", len(da))
+	}
+	for ns, hash := range da {
+		if db[ns] != hash {
+			t.Fatalf("digest for namespace %q diverged between identical replicas: %q != %q", ns, hash, db[ns])
+		}
+	}
+}
+
+func TestClusterNamespaceDigestsDivergeOnDifferentState(t *testing.T) {
+	a := newTestCluster()
+	b := newTestCluster()
+
+	a.merge(clusterRecord{Namespace: "ns1", PeerID: "peer1", Expire: 100})
+	b.merge(clusterRecord{Namespace: "ns1", PeerID: "peer1", Expire: 200})
+
+	if a.namespaceDigests()["ns1"] == b.namespaceDigests()["ns1"] {
+		t.Fatal("digests for diverged namespaces should not match")
+	}
+}
+
+func TestClusterRecordsForNamespaces(t *testing.T) {
+	c := newTestCluster()
+	c.merge(clusterRecord{Namespace: "ns1", PeerID: "peer1", Expire: 100})
+	c.merge(clusterRecord{Namespace: "ns2", PeerID: "peer1", Expire: 100})
+
+	recs := c.recordsForNamespaces(map[string]struct{}{"ns1": {}})
+	if len(recs) != 1 || recs[0].Namespace != "ns1" {
+		t.Fatalf("expected only ns1's record, got %+v", recs)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	libp2p_peer "github.com/libp2p/go-libp2p-core/peer"
+	libp2p_rp "github.com/libp2p/go-libp2p-rendezvous"
+
+	berty_rdvp "berty.tech/berty/v2/go/internal/rendezvous"
+	"berty.tech/berty/v2/go/pkg/rdvpstore"
+)
+
+// instrumentedDB wraps a rdvpstore.Store so every Register/Unregister/
+// Discover call updates rdvpMetrics, without touching the store
+// implementations themselves.
+type instrumentedDB struct {
+	rdvpstore.Store
+
+	metrics *rdvpMetrics
+}
+
+func newInstrumentedDB(store rdvpstore.Store, metrics *rdvpMetrics) *instrumentedDB {
+	return &instrumentedDB{Store: store, metrics: metrics}
+}
+
+func (db *instrumentedDB) Register(ns string, pi libp2p_peer.AddrInfo, ttl int) (string, error) {
+	start := time.Now()
+	cookie, err := db.Store.Register(ns, pi, ttl)
+	db.metrics.observeRPC("register", start, err)
+	return cookie, err
+}
+
+func (db *instrumentedDB) Unregister(ns string, p libp2p_peer.ID) error {
+	start := time.Now()
+	err := db.Store.Unregister(ns, p)
+	db.metrics.observeRPC("unregister", start, err)
+	return err
+}
+
+func (db *instrumentedDB) Discover(ns string, cookie []byte, limit int) ([]libp2p_rp.Registration, []byte, error) {
+	start := time.Now()
+	regs, newCookie, err := db.Store.Discover(ns, cookie, limit)
+	db.metrics.observeRPC("discover", start, err)
+	return regs, newCookie, err
+}
+
+// pubsubDB wraps a rendezvous DB so every Register/Unregister also publishes
+// an AnnounceEvent on the namespace's GossipSub topic, letting clients that
+// subscribe via the rendezvous package skip polling Discover entirely.
+type pubsubDB struct {
+	libp2p_rp.DB
+
+	announcer *berty_rdvp.Announcer
+}
+
+func newPubsubDB(db libp2p_rp.DB, announcer *berty_rdvp.Announcer) *pubsubDB {
+	return &pubsubDB{DB: db, announcer: announcer}
+}
+
+func (db *pubsubDB) Register(ns string, pi libp2p_peer.AddrInfo, ttl int) (string, error) {
+	cookie, err := db.DB.Register(ns, pi, ttl)
+	if err == nil {
+		_ = db.announcer.Publish(context.Background(), ns, pi, false)
+	}
+	return cookie, err
+}
+
+func (db *pubsubDB) Unregister(ns string, p libp2p_peer.ID) error {
+	err := db.DB.Unregister(ns, p)
+	if err == nil {
+		_ = db.announcer.Publish(context.Background(), ns, libp2p_peer.AddrInfo{ID: p}, true)
+	}
+	return err
+}
+
+// clusterDB wraps a rendezvous DB so every Register/Unregister is recorded
+// into the cluster's CRDT table and broadcast to peer rdvp instances.
+type clusterDB struct {
+	libp2p_rp.DB
+
+	cluster *cluster
+}
+
+func newClusterDB(db libp2p_rp.DB, cluster *cluster) *clusterDB {
+	return &clusterDB{DB: db, cluster: cluster}
+}
+
+func (db *clusterDB) Register(ns string, pi libp2p_peer.AddrInfo, ttl int) (string, error) {
+	cookie, err := db.DB.Register(ns, pi, ttl)
+	if err == nil {
+		addrs := make([]string, len(pi.Addrs))
+		for i, a := range pi.Addrs {
+			addrs[i] = a.String()
+		}
+		db.cluster.recordLocal(clusterRecord{
+			Namespace: ns,
+			PeerID:    pi.ID.Pretty(),
+			Addrs:     addrs,
+			Expire:    time.Now().Add(time.Duration(ttl) * time.Second).Unix(),
+		})
+	}
+	return cookie, err
+}
+
+func (db *clusterDB) Unregister(ns string, p libp2p_peer.ID) error {
+	err := db.DB.Unregister(ns, p)
+	if err == nil {
+		db.cluster.recordLocal(clusterRecord{
+			Namespace: ns,
+			PeerID:    p.Pretty(),
+			Expire:    time.Now().Unix(),
+			Tombstone: true,
+		})
+	}
+	return err
+}
+
+// gcLoop periodically calls store.GC until ctx is cancelled. Drivers like
+// postgres have no other path that deletes expired registrations, so
+// without this a long-running rdvp leaks a row per expired registration.
+func gcLoop(ctx context.Context, store rdvpstore.Store) error {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			_ = store.GC(ctx)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	libp2p_ci "github.com/libp2p/go-libp2p-core/crypto" // nolint:staticcheck
+
+	"golang.org/x/crypto/hkdf"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// identitySeedSize matches ed25519.SeedSize; duplicated here to avoid an
+// import of crypto/ed25519 just for the constant.
+const identitySeedSize = 32
+
+// loadOrGenerateIdentity loads a base64-encoded private key from path,
+// generating and persisting a new Ed25519 identity if the file doesn't
+// exist yet. Keys previously written in RSA form (rdvp's old default) load
+// just as well, since UnmarshalPrivateKey dispatches on the key's own type.
+func loadOrGenerateIdentity(path string) (libp2p_ci.PrivKey, error) {
+	raw, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		kBytes, derr := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+		if derr != nil {
+			return nil, errcode.TODO.Wrap(derr)
+		}
+		return libp2p_ci.UnmarshalPrivateKey(kBytes)
+
+	case os.IsNotExist(err):
+		priv, _, genErr := libp2p_ci.GenerateKeyPairWithReader(libp2p_ci.Ed25519, -1, crand.Reader)
+		if genErr != nil {
+			return nil, errcode.TODO.Wrap(genErr)
+		}
+		if err := writeIdentity(path, priv); err != nil {
+			return nil, err
+		}
+		return priv, nil
+
+	default:
+		return nil, errcode.TODO.Wrap(err)
+	}
+}
+
+// writeIdentity persists priv to path, base64-encoded, matching the format
+// accepted by `-pk`/RDVP_PK.
+func writeIdentity(path string, priv libp2p_ci.PrivKey) error {
+	kBytes, err := libp2p_ci.MarshalPrivateKey(priv)
+	if err != nil {
+		return errcode.TODO.Wrap(err)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(kBytes)
+	if err := ioutil.WriteFile(path, []byte(encoded), 0o600); err != nil {
+		return errcode.TODO.Wrap(err)
+	}
+	return nil
+}
+
+// deriveIdentity deterministically derives an Ed25519 identity from a seed
+// phrase, for reproducible devnet deployments where every node needs a
+// stable, regenerable peer ID (mirroring wormhole's
+// DeterministicP2PPrivKeyByIndex).
+func deriveIdentity(seed string) (libp2p_ci.PrivKey, error) {
+	kdf := hkdf.New(sha256.New, []byte(seed), nil, []byte("berty-rdvp-identity-v1"))
+
+	seedBytes := make([]byte, identitySeedSize)
+	if _, err := io.ReadFull(kdf, seedBytes); err != nil {
+		return nil, errcode.TODO.Wrap(err)
+	}
+
+	priv, _, err := libp2p_ci.GenerateEd25519Key(bytes.NewReader(seedBytes))
+	if err != nil {
+		return nil, errcode.TODO.Wrap(err)
+	}
+
+	return priv, nil
+}
@@ -12,16 +12,19 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	berty_rdvp "berty.tech/berty/v2/go/internal/rendezvous"
 	"berty.tech/berty/v2/go/pkg/errcode"
+	"berty.tech/berty/v2/go/pkg/rdvpstore"
 	libp2p "github.com/libp2p/go-libp2p"
 	libp2p_cicuit "github.com/libp2p/go-libp2p-circuit"
 	libp2p_ci "github.com/libp2p/go-libp2p-core/crypto" // nolint:staticcheck
 	libp2p_host "github.com/libp2p/go-libp2p-core/host"
+	libp2p_metrics "github.com/libp2p/go-libp2p-core/metrics"
 	libp2p_peer "github.com/libp2p/go-libp2p-core/peer"
 	libp2p_quic "github.com/libp2p/go-libp2p-quic-transport"
 	libp2p_rp "github.com/libp2p/go-libp2p-rendezvous"
-	libp2p_rpdb "github.com/libp2p/go-libp2p-rendezvous/db/sqlite"
 
 	ipfs_log "github.com/ipfs/go-log"
 
@@ -29,6 +32,7 @@ import (
 	"github.com/oklog/run"
 	"github.com/peterbourgon/ff"
 	"github.com/peterbourgon/ff/ffcli"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"moul.io/srand"
@@ -46,9 +50,16 @@ func main() {
 		globalLogToFile = globalFlags.String("logfile", "", "if specified, will log everything in JSON into a file and nothing on stderr")
 
 		serveFlags          = flag.NewFlagSet("serve", flag.ExitOnError)
-		serveFlagsURN       = serveFlags.String("db", ":memory:", "rdvp sqlite URN")
+		serveFlagsURN       = serveFlags.String("db", ":memory:", "rdvp db URN (driver-specific, see -db-driver)")
+		serveFlagsDBDriver  = serveFlags.String("db-driver", "sqlite", fmt.Sprintf("storage backend for registrations (%s)", strings.Join(rdvpstore.Drivers(), ", ")))
 		serveFlagsListeners = serveFlags.String("l", "/ip4/0.0.0.0/tcp/4040,/ip4/0.0.0.0/udp/4141/quic", "lists of listeners of (m)addrs separate by a comma")
 		serveFlagsPK        = serveFlags.String("pk", "", "private key (generated by `rdvp genkey`)")
+		serveFlagsIdentity  = serveFlags.String("identity", "", "path to an identity key file, loaded or generated on first run (preferred over -pk)")
+		serveFlagsMetrics   = serveFlags.String("metrics", "", "if specified, expose Prometheus metrics and pprof on this addr (e.g. :8989)")
+		serveFlagsPubsub    = serveFlags.Bool("mode-pubsub", false, "announce registration changes over GossipSub instead of relying only on pull-based Discover")
+		serveFlagsPeers     = serveFlags.String("peers", "", "comma-separated list of rdvp peer multiaddrs (/.../p2p/<id>) to replicate registrations with")
+		serveFlagsPSK       = serveFlags.String("psk", "", "if specified, only accept connections from peers sharing this pre-shared key (swarm.key format)")
+		serveFlagsACL       = serveFlags.String("acl", "", "if specified, gate Register calls by the namespace ACL defined in this YAML/JSON file")
 	)
 
 	globalPreRun := func() (err error) {
@@ -77,6 +88,21 @@ func main() {
 			ctx, cancel := context.WithCancel(ctx)
 			defer cancel()
 
+			// serve's optional components (metrics server, stat pollers, GC
+			// loop, cluster reconciliation) are actors on this group, not the
+			// outer `process` group: `process.Run()` has already ranged over
+			// the outer group's actors by the time Exec runs (Exec itself is
+			// the body of one of them), so anything added to `process` from in
+			// here would never start. srv.Run() below is what actually runs
+			// them.
+			var srv run.Group
+			srv.Add(func() error {
+				<-ctx.Done()
+				return ctx.Err()
+			}, func(error) {
+				cancel()
+			})
+
 			laddrs := strings.Split(*serveFlagsListeners, ",")
 			listeners, err := parseAddrs(laddrs...)
 			if err != nil {
@@ -85,7 +111,14 @@ func main() {
 
 			// load existing or generate new identity
 			var priv libp2p_ci.PrivKey
-			if *serveFlagsPK != "" {
+			switch {
+			case *serveFlagsIdentity != "":
+				priv, err = loadOrGenerateIdentity(*serveFlagsIdentity)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+
+			case *serveFlagsPK != "":
 				kBytes, err := base64.StdEncoding.DecodeString(*serveFlagsPK)
 				if err != nil {
 					return errcode.TODO.Wrap(err)
@@ -94,15 +127,25 @@ func main() {
 				if err != nil {
 					return errcode.TODO.Wrap(err)
 				}
-			} else {
-				priv, _, err = libp2p_ci.GenerateKeyPairWithReader(libp2p_ci.RSA, 2048, crand.Reader) // nolint:staticcheck
+
+			default:
+				priv, _, err = libp2p_ci.GenerateKeyPairWithReader(libp2p_ci.Ed25519, -1, crand.Reader)
 				if err != nil {
 					return errcode.TODO.Wrap(err)
 				}
 			}
 
+			var bwc *libp2p_metrics.BandwidthCounter
+			var rdvpM *rdvpMetrics
+			var metricsReg *prometheus.Registry
+			if *serveFlagsMetrics != "" {
+				bwc = libp2p_metrics.NewBandwidthCounter()
+				metricsReg = prometheus.NewRegistry()
+				rdvpM = newRdvpMetrics(metricsReg)
+			}
+
 			// init p2p host
-			host, err := libp2p.New(ctx,
+			hostOpts := []libp2p.Option{
 				// default tpt + quic
 				libp2p.DefaultTransports,
 				libp2p.Transport(libp2p_quic.NewTransport),
@@ -117,25 +160,105 @@ func main() {
 
 				// identity
 				libp2p.Identity(priv),
-			)
+			}
+			if bwc != nil {
+				hostOpts = append(hostOpts, libp2p.BandwidthReporter(bwc))
+			}
+			if *serveFlagsPSK != "" {
+				psk, err := loadPSK(*serveFlagsPSK)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+				hostOpts = append(hostOpts, libp2p.PrivateNetwork(psk))
+			}
+
+			// ACL is loaded before the host is constructed (rather than next to
+			// where it's wrapped onto rpDB below) so its deny rules can also be
+			// enforced as a libp2p.ConnectionGater: rpDB.Discover has no way to
+			// see which peer is asking (see aclDB's doc comment), so a peer
+			// denied everywhere by a wildcard rule is instead refused the
+			// underlying connection outright, before it ever reaches Discover.
+			var acl *ACL
+			if *serveFlagsACL != "" {
+				acl, err = loadACL(*serveFlagsACL)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+				hostOpts = append(hostOpts, libp2p.ConnectionGater(newACLConnGater(acl)))
+			}
+
+			host, err := libp2p.New(ctx, hostOpts...)
 			if err != nil {
 				return errcode.TODO.Wrap(err)
 			}
 			defer host.Close()
 			logHostInfo(logger, host)
 
-			db, err := libp2p_rpdb.OpenDB(ctx, *serveFlagsURN)
+			store, err := rdvpstore.Open(ctx, *serveFlagsDBDriver, *serveFlagsURN)
 			if err != nil {
 				return errcode.TODO.Wrap(err)
 			}
 
-			defer db.Close()
+			defer store.Close()
+
+			srv.Add(newRunGroupActor(ctx, func(ctx context.Context) error {
+				return gcLoop(ctx, store)
+			}))
 
 			// start service
-			_ = libp2p_rp.NewRendezvousService(host, db)
+			var rpDB libp2p_rp.DB = store
+			if rdvpM != nil {
+				rpDB = newInstrumentedDB(store, rdvpM)
+
+				srv.Add(newMetricsServer(*serveFlagsMetrics, metricsReg))
+				srv.Add(newRunGroupActor(ctx, func(ctx context.Context) error {
+					return rdvpM.pollHostStats(ctx, host, bwc)
+				}))
+				if *serveFlagsDBDriver == "sqlite" {
+					srv.Add(newRunGroupActor(ctx, func(ctx context.Context) error {
+						return rdvpM.pollDBStats(ctx, *serveFlagsURN)
+					}))
+				}
+
+				logger.Info("metrics endpoint enabled", zap.String("addr", *serveFlagsMetrics))
+			}
 
-			<-ctx.Done()
-			if err = ctx.Err(); err != nil {
+			if *serveFlagsPubsub {
+				ps, err := berty_rdvp.NewGossipSub(ctx, host)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+				rpDB = newPubsubDB(rpDB, berty_rdvp.NewAnnouncer(ps))
+
+				logger.Info("pubsub announce mode enabled")
+			}
+
+			if acl != nil {
+				rpDB = newACLDB(rpDB, acl)
+
+				logger.Info("ACL enabled", zap.String("path", *serveFlagsACL), zap.Int("rules", len(acl.Rules)))
+			}
+
+			// clustering is wired in after the ACL wrapper (not just after it in
+			// the chain, but with the ACL-wrapped rpDB handed to newCluster) so
+			// replicated writes from -peers are ACL-checked exactly like local
+			// Register calls, instead of bypassing the ACL entirely.
+			if *serveFlagsPeers != "" {
+				clusterPeers, err := parsePeerAddrs(strings.Split(*serveFlagsPeers, ",")...)
+				if err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+
+				rdvpCluster := newCluster(host, rpDB, clusterPeers, logger)
+				host.SetStreamHandler(clusterProtocolID, rdvpCluster.handleStream)
+				rpDB = newClusterDB(rpDB, rdvpCluster)
+
+				srv.Add(newRunGroupActor(ctx, rdvpCluster.reconcileAll))
+				logger.Info("clustering enabled", zap.Int("peers", len(clusterPeers)))
+			}
+			_ = libp2p_rp.NewRendezvousService(host, rpDB)
+
+			if err := srv.Run(); err != nil && err != context.Canceled {
 				return errcode.TODO.Wrap(err)
 			}
 			return nil
@@ -145,7 +268,7 @@ func main() {
 	genkey := &ffcli.Command{
 		Name: "genkey",
 		Exec: func(args []string) error {
-			priv, _, err := libp2p_ci.GenerateKeyPairWithReader(libp2p_ci.RSA, 2048, crand.Reader) // nolint:staticcheck
+			priv, _, err := libp2p_ci.GenerateKeyPairWithReader(libp2p_ci.Ed25519, -1, crand.Reader)
 			if err != nil {
 				return errcode.TODO.Wrap(err)
 			}
@@ -160,11 +283,120 @@ func main() {
 		},
 	}
 
+	identityShowFlags := flag.NewFlagSet("identity show", flag.ExitOnError)
+	identityShowPath := identityShowFlags.String("identity", "", "path to identity key file")
+	identityShow := &ffcli.Command{
+		Name:    "show",
+		Usage:   "identity show -identity <path>",
+		FlagSet: identityShowFlags,
+		Exec: func(args []string) error {
+			if *identityShowPath == "" {
+				return errcode.TODO.Wrap(fmt.Errorf("-identity is required"))
+			}
+
+			priv, err := loadOrGenerateIdentity(*identityShowPath)
+			if err != nil {
+				return err
+			}
+
+			pid, err := libp2p_peer.IDFromPrivateKey(priv)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+			fmt.Println(pid.Pretty())
+			return nil
+		},
+	}
+
+	identityRotateFlags := flag.NewFlagSet("identity rotate", flag.ExitOnError)
+	identityRotatePath := identityRotateFlags.String("identity", "", "path to identity key file")
+	identityRotateAccept := identityRotateFlags.Bool("accept-peer-id-change", false, "confirm that rotating the identity will change this node's peer ID")
+	identityRotate := &ffcli.Command{
+		Name:    "rotate",
+		Usage:   "identity rotate -identity <path> -accept-peer-id-change",
+		FlagSet: identityRotateFlags,
+		Exec: func(args []string) error {
+			if *identityRotatePath == "" {
+				return errcode.TODO.Wrap(fmt.Errorf("-identity is required"))
+			}
+			if !*identityRotateAccept {
+				return errcode.TODO.Wrap(fmt.Errorf("rotating the identity changes this node's peer ID; pass -accept-peer-id-change to confirm"))
+			}
+
+			if _, err := os.Stat(*identityRotatePath); err == nil {
+				backup := fmt.Sprintf("%s.%d.bak", *identityRotatePath, time.Now().Unix())
+				if err := os.Rename(*identityRotatePath, backup); err != nil {
+					return errcode.TODO.Wrap(err)
+				}
+				fmt.Fprintf(os.Stderr, "previous identity saved to %s\n", backup)
+			}
+
+			priv, _, err := libp2p_ci.GenerateKeyPairWithReader(libp2p_ci.Ed25519, -1, crand.Reader)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+			if err := writeIdentity(*identityRotatePath, priv); err != nil {
+				return err
+			}
+
+			pid, err := libp2p_peer.IDFromPrivateKey(priv)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+			fmt.Println(pid.Pretty())
+			return nil
+		},
+	}
+
+	identityDeriveFlags := flag.NewFlagSet("identity derive", flag.ExitOnError)
+	identityDeriveSeed := identityDeriveFlags.String("seed", "", "seed phrase to deterministically derive the identity from")
+	identityDerivePath := identityDeriveFlags.String("identity", "", "if specified, write the derived key to this path instead of only printing the peer ID")
+	identityDerive := &ffcli.Command{
+		Name:    "derive",
+		Usage:   "identity derive -seed <phrase> [-identity <path>]",
+		FlagSet: identityDeriveFlags,
+		Exec: func(args []string) error {
+			if *identityDeriveSeed == "" {
+				return errcode.TODO.Wrap(fmt.Errorf("-seed is required"))
+			}
+
+			priv, err := deriveIdentity(*identityDeriveSeed)
+			if err != nil {
+				return err
+			}
+
+			if *identityDerivePath != "" {
+				if err := writeIdentity(*identityDerivePath, priv); err != nil {
+					return err
+				}
+			}
+
+			pid, err := libp2p_peer.IDFromPrivateKey(priv)
+			if err != nil {
+				return errcode.TODO.Wrap(err)
+			}
+			fmt.Println(pid.Pretty())
+			return nil
+		},
+	}
+
+	identityFlags := flag.NewFlagSet("identity", flag.ExitOnError)
+	identity := &ffcli.Command{
+		Name:        "identity",
+		Usage:       "identity <show|rotate|derive> [flags]",
+		FlagSet:     identityFlags,
+		Subcommands: []*ffcli.Command{identityShow, identityRotate, identityDerive},
+		Exec: func([]string) error {
+			identityFlags.Usage()
+			return flag.ErrHelp
+		},
+	}
+
 	root := &ffcli.Command{
 		Usage:       "rdvp [global flags] <subcommand> [flags] [args...]",
 		FlagSet:     globalFlags,
 		Options:     []ff.Option{ff.WithEnvVarPrefix("RDVP")},
-		Subcommands: []*ffcli.Command{serve, genkey},
+		Subcommands: []*ffcli.Command{serve, genkey, identity},
 		Exec: func([]string) error {
 			globalFlags.Usage()
 			return flag.ErrHelp
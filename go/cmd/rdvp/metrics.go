@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+
+	libp2p_host "github.com/libp2p/go-libp2p-core/host"
+	libp2p_metrics "github.com/libp2p/go-libp2p-core/metrics"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// rdvpMetrics holds every collector exposed by the `-metrics` endpoint. It is
+// created once per `serve` invocation and threaded through the host/DB
+// instrumentation below.
+type rdvpMetrics struct {
+	rpcDuration    *prometheus.HistogramVec
+	rpcTotal       *prometheus.CounterVec
+	registrations  *prometheus.GaugeVec
+	namespaces     prometheus.Gauge
+	connsTotal     prometheus.Gauge
+	streamsTotal   *prometheus.GaugeVec
+	relayedConns   prometheus.Gauge
+	bandwidthTotal *prometheus.GaugeVec
+}
+
+func newRdvpMetrics(reg prometheus.Registerer) *rdvpMetrics {
+	factory := promauto.With(reg)
+
+	return &rdvpMetrics{
+		rpcDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "rdvp",
+			Subsystem: "rpc",
+			Name:      "duration_seconds",
+			Help:      "Duration of rendezvous RPCs handled by this node.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		rpcTotal: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "rdvp",
+			Subsystem: "rpc",
+			Name:      "total",
+			Help:      "Total number of rendezvous RPCs handled by this node, by method and result.",
+		}, []string{"method", "result"}),
+		registrations: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "rdvp",
+			Name:      "registrations",
+			Help:      "Current number of live registrations per namespace.",
+		}, []string{"namespace"}),
+		namespaces: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: "rdvp",
+			Name:      "namespaces",
+			Help:      "Current number of distinct namespaces with at least one registration.",
+		}),
+		connsTotal: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: "rdvp",
+			Subsystem: "host",
+			Name:      "connections",
+			Help:      "Current number of libp2p connections.",
+		}),
+		streamsTotal: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "rdvp",
+			Subsystem: "host",
+			Name:      "streams",
+			Help:      "Current number of open libp2p streams, by protocol.",
+		}, []string{"protocol"}),
+		relayedConns: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: "rdvp",
+			Subsystem: "host",
+			Name:      "relayed_connections",
+			Help:      "Current number of connections established through a circuit relay.",
+		}),
+		bandwidthTotal: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "rdvp",
+			Subsystem: "host",
+			Name:      "bandwidth_bytes_total",
+			Help:      "Cumulative bandwidth usage of the libp2p host.",
+		}, []string{"direction"}),
+	}
+}
+
+// observeRPC records the outcome of a rendezvous RPC (register/unregister/
+// discover), as called out from the wrapped DB in db.go.
+func (m *rdvpMetrics) observeRPC(method string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.rpcTotal.WithLabelValues(method, result).Inc()
+	m.rpcDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// pollHostStats periodically samples the libp2p host (connections, streams
+// per protocol, connections over a circuit relay, bandwidth) until ctx is
+// cancelled; NAT traversal itself is not measured. It is meant to be run as
+// an `oklog/run` actor.
+func (m *rdvpMetrics) pollHostStats(ctx context.Context, host libp2p_host.Host, bwc *libp2p_metrics.BandwidthCounter) error {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	sample := func() {
+		conns := host.Network().Conns()
+		m.connsTotal.Set(float64(len(conns)))
+
+		streamsPerProto := map[string]int{}
+		relayed := 0
+		for _, conn := range conns {
+			if strings.Contains(conn.RemoteMultiaddr().String(), "/p2p-circuit") {
+				relayed++
+			}
+			for _, stream := range conn.GetStreams() {
+				streamsPerProto[string(stream.Protocol())]++
+			}
+		}
+
+		m.streamsTotal.Reset()
+		for proto, count := range streamsPerProto {
+			m.streamsTotal.WithLabelValues(proto).Set(float64(count))
+		}
+		m.relayedConns.Set(float64(relayed))
+
+		if bwc != nil {
+			totals := bwc.GetBandwidthTotals()
+			m.bandwidthTotal.WithLabelValues("in").Set(float64(totals.TotalIn))
+			m.bandwidthTotal.WithLabelValues("out").Set(float64(totals.TotalOut))
+		}
+	}
+
+	sample()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			sample()
+		}
+	}
+}
+
+// pollDBStats periodically samples the registration/namespace counts directly
+// from the rendezvous sqlite URN until ctx is cancelled. It opens its own
+// read-only connection so it never contends with the rendezvous service's
+// writes.
+func (m *rdvpMetrics) pollDBStats(ctx context.Context, urn string) error {
+	if urn == ":memory:" || strings.HasPrefix(urn, ":memory:") {
+		// an in-memory DB can't be opened from a second connection; skip.
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+urn+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	sample := func() {
+		rows, err := db.QueryContext(ctx, `SELECT ns, COUNT(*) FROM registrations WHERE expire > ? GROUP BY ns`, time.Now().Unix())
+		if err != nil {
+			return
+		}
+		defer rows.Close()
+
+		counts := map[string]int{}
+		for rows.Next() {
+			var ns string
+			var count int
+			if err := rows.Scan(&ns, &count); err != nil {
+				continue
+			}
+			counts[ns] = count
+		}
+
+		m.registrations.Reset()
+		for ns, count := range counts {
+			m.registrations.WithLabelValues(ns).Set(float64(count))
+		}
+		m.namespaces.Set(float64(len(counts)))
+	}
+
+	sample()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			sample()
+		}
+	}
+}
+
+// newMetricsServer builds the `-metrics` HTTP server exposing `/metrics`
+// (Prometheus) and `/debug/pprof/*` (pprof), wired as an `oklog/run` actor
+// so it shares lifecycle with the rest of `serve`.
+func newMetricsServer(addr string, reg *prometheus.Registry) (execute func() error, interrupt func(error)) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	execute = func() error {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		return srv.Serve(listener)
+	}
+	interrupt = func(error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}
+
+	return execute, interrupt
+}
+
+// newRunGroupActor is a tiny helper so call sites in main.go can add the
+// metrics server and stat pollers to the existing `oklog/run.Group` with one
+// line each.
+func newRunGroupActor(ctx context.Context, fn func(context.Context) error) (execute func() error, interrupt func(error)) {
+	ctx, cancel := context.WithCancel(ctx)
+	execute = func() error { return fn(ctx) }
+	interrupt = func(error) { cancel() }
+	return execute, interrupt
+}
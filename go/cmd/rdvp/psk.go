@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+
+	libp2p_pnet "github.com/libp2p/go-libp2p-core/pnet"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// loadPSK reads a libp2p private-network pre-shared key from path, in the
+// same "/key/swarm/psk/1.0.0/.../base16/..." textual format used by IPFS
+// swarm.key files.
+func loadPSK(path string) (libp2p_pnet.PSK, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errcode.TODO.Wrap(err)
+	}
+	defer f.Close()
+
+	psk, err := libp2p_pnet.DecodeV1PSK(f)
+	if err != nil {
+		return nil, errcode.TODO.Wrap(err)
+	}
+
+	return psk, nil
+}
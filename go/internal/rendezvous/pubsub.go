@@ -0,0 +1,93 @@
+// Package rendezvous provides a small GossipSub helper layered on top of a
+// rdvp rendezvous point: servers announce Register/Unregister mutations on a
+// per-namespace topic, and clients (Watcher) maintain an eventually
+// consistent view of a namespace without having to poll Discover.
+package rendezvous
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	libp2p_host "github.com/libp2p/go-libp2p-core/host"
+	libp2p_peer "github.com/libp2p/go-libp2p-core/peer"
+	libp2p_pubsub "github.com/libp2p/go-libp2p-pubsub"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// AnnounceTopic returns the GossipSub topic on which registration changes
+// for namespace are announced.
+func AnnounceTopic(namespace string) string {
+	return fmt.Sprintf("/rdvp/announce/%s", namespace)
+}
+
+// AnnounceEvent is published whenever a registration in a namespace is added
+// or removed.
+type AnnounceEvent struct {
+	Namespace string               `json:"namespace"`
+	Peer      libp2p_peer.AddrInfo `json:"peer"`
+	Removed   bool                 `json:"removed,omitempty"`
+}
+
+// NewGossipSub starts a GossipSub router on host. go-libp2p-pubsub only
+// supports one active router per host, so callers that need more than one
+// Announcer/Watcher on the same host (e.g. watching several namespaces at
+// once) must start the router once here and share the result, rather than
+// letting each one start its own.
+func NewGossipSub(ctx context.Context, host libp2p_host.Host) (*libp2p_pubsub.PubSub, error) {
+	ps, err := libp2p_pubsub.NewGossipSub(ctx, host)
+	if err != nil {
+		return nil, errcode.TODO.Wrap(err)
+	}
+	return ps, nil
+}
+
+// Announcer publishes AnnounceEvents for namespaces mutated on this rdvp
+// instance. It is safe for concurrent use.
+type Announcer struct {
+	ps *libp2p_pubsub.PubSub
+
+	mu     sync.Mutex
+	topics map[string]*libp2p_pubsub.Topic
+}
+
+// NewAnnouncer returns an Announcer that publishes on ps, ready to publish on
+// namespace topics as they are joined. ps should be shared (see
+// NewGossipSub) with any other Announcer/Watcher on the same host.
+func NewAnnouncer(ps *libp2p_pubsub.PubSub) *Announcer {
+	return &Announcer{ps: ps, topics: make(map[string]*libp2p_pubsub.Topic)}
+}
+
+func (a *Announcer) topic(namespace string) (*libp2p_pubsub.Topic, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if t, ok := a.topics[namespace]; ok {
+		return t, nil
+	}
+
+	t, err := a.ps.Join(AnnounceTopic(namespace))
+	if err != nil {
+		return nil, errcode.TODO.Wrap(err)
+	}
+	a.topics[namespace] = t
+	return t, nil
+}
+
+// Publish announces that pi was registered (or unregistered, if removed is
+// true) in namespace.
+func (a *Announcer) Publish(ctx context.Context, namespace string, pi libp2p_peer.AddrInfo, removed bool) error {
+	t, err := a.topic(namespace)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(AnnounceEvent{Namespace: namespace, Peer: pi, Removed: removed})
+	if err != nil {
+		return errcode.TODO.Wrap(err)
+	}
+
+	return t.Publish(ctx, payload)
+}
@@ -0,0 +1,79 @@
+package rendezvous
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	libp2p_peer "github.com/libp2p/go-libp2p-core/peer"
+	libp2p_pubsub "github.com/libp2p/go-libp2p-pubsub"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// Watcher subscribes to a namespace's announce topic and maintains an
+// eventually-consistent view of its registered peers, so callers don't have
+// to poll Discover to notice group presence changes.
+type Watcher struct {
+	sub *libp2p_pubsub.Subscription
+
+	mu    sync.RWMutex
+	peers map[libp2p_peer.ID]libp2p_peer.AddrInfo
+}
+
+// NewWatcher joins namespace's announce topic on ps and starts tracking
+// registrations until ctx is cancelled. ps should be shared (see
+// NewGossipSub) with any other Announcer/Watcher on the same host, since
+// go-libp2p-pubsub only supports one active router per host: a caller
+// watching several namespaces must reuse one ps across every NewWatcher
+// call instead of standing up a router per namespace.
+func NewWatcher(ctx context.Context, ps *libp2p_pubsub.PubSub, namespace string) (*Watcher, error) {
+	topic, err := ps.Join(AnnounceTopic(namespace))
+	if err != nil {
+		return nil, errcode.TODO.Wrap(err)
+	}
+
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return nil, errcode.TODO.Wrap(err)
+	}
+
+	w := &Watcher{sub: sub, peers: make(map[libp2p_peer.ID]libp2p_peer.AddrInfo)}
+	go w.loop(ctx)
+	return w, nil
+}
+
+func (w *Watcher) loop(ctx context.Context) {
+	for {
+		msg, err := w.sub.Next(ctx)
+		if err != nil {
+			// ctx cancelled or subscription closed
+			return
+		}
+
+		var event AnnounceEvent
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			continue
+		}
+
+		w.mu.Lock()
+		if event.Removed {
+			delete(w.peers, event.Peer.ID)
+		} else {
+			w.peers[event.Peer.ID] = event.Peer
+		}
+		w.mu.Unlock()
+	}
+}
+
+// Peers returns the current known set of registered peers in the namespace.
+func (w *Watcher) Peers() []libp2p_peer.AddrInfo {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	peers := make([]libp2p_peer.AddrInfo, 0, len(w.peers))
+	for _, pi := range w.peers {
+		peers = append(peers, pi)
+	}
+	return peers
+}
@@ -0,0 +1,129 @@
+package rdvpstore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	libp2p_peer "github.com/libp2p/go-libp2p-core/peer"
+	libp2p_rp "github.com/libp2p/go-libp2p-rendezvous"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+func init() {
+	Register("badger", openBadger)
+}
+
+// badgerStore keys one registration per `<namespace>/<peerID>` and relies on
+// badger's own per-entry TTL to expire stale entries instead of a
+// background sweep.
+type badgerStore struct {
+	db *badger.DB
+}
+
+func openBadger(ctx context.Context, urn string) (Store, error) {
+	opts := badger.DefaultOptions(urn)
+	opts.Logger = nil
+
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, errcode.TODO.Wrap(err)
+	}
+	return &badgerStore{db: db}, nil
+}
+
+type badgerRegistration struct {
+	Peer libp2p_peer.AddrInfo `json:"peer"`
+}
+
+func badgerKey(ns string, p libp2p_peer.ID) []byte {
+	return []byte(ns + "/" + p.Pretty())
+}
+
+func (s *badgerStore) Register(ns string, pi libp2p_peer.AddrInfo, ttl int) (string, error) {
+	payload, err := json.Marshal(badgerRegistration{Peer: pi})
+	if err != nil {
+		return "", errcode.TODO.Wrap(err)
+	}
+
+	err = s.db.Update(func(txn *badger.Txn) error {
+		entry := badger.NewEntry(badgerKey(ns, pi.ID), payload).WithTTL(time.Duration(ttl) * time.Second)
+		return txn.SetEntry(entry)
+	})
+	if err != nil {
+		return "", errcode.TODO.Wrap(err)
+	}
+
+	return pi.ID.Pretty(), nil
+}
+
+func (s *badgerStore) Unregister(ns string, p libp2p_peer.ID) error {
+	err := s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(badgerKey(ns, p))
+	})
+	if err != nil {
+		return errcode.TODO.Wrap(err)
+	}
+	return nil
+}
+
+// Discover paginates by key: cookie, when set, is the last key returned by
+// the previous call, and iteration resumes just past it. Keys are iterated
+// in badger's natural (lexicographic) order, so this is stable across calls
+// as long as the namespace isn't concurrently rewritten.
+func (s *badgerStore) Discover(ns string, cookie []byte, limit int) ([]libp2p_rp.Registration, []byte, error) {
+	var regs []libp2p_rp.Registration
+	var newCookie []byte
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte(ns + "/")
+		seek := prefix
+		if len(cookie) > 0 {
+			seek = cookie
+		}
+
+		for it.Seek(seek); it.ValidForPrefix(prefix); it.Next() {
+			key := it.Item().KeyCopy(nil)
+			if len(cookie) > 0 && string(key) <= string(cookie) {
+				continue
+			}
+			if limit > 0 && len(regs) >= limit {
+				break
+			}
+
+			var reg badgerRegistration
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &reg)
+			})
+			if err != nil {
+				continue
+			}
+			regs = append(regs, libp2p_rp.Registration{Ns: ns, Peer: reg.Peer})
+			newCookie = key
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errcode.TODO.Wrap(err)
+	}
+
+	return regs, newCookie, nil
+}
+
+func (s *badgerStore) Cleanup() {
+	_ = s.db.RunValueLogGC(0.5)
+}
+
+func (s *badgerStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *badgerStore) GC(ctx context.Context) error {
+	s.Cleanup()
+	return nil
+}
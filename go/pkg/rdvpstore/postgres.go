@@ -0,0 +1,148 @@
+package rdvpstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	libp2p_peer "github.com/libp2p/go-libp2p-core/peer"
+	libp2p_rp "github.com/libp2p/go-libp2p-rendezvous"
+
+	_ "github.com/lib/pq"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+func init() {
+	Register("postgres", openPostgres)
+}
+
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS rdvp_registrations (
+	namespace TEXT NOT NULL,
+	peer_id   TEXT NOT NULL,
+	addrs     JSONB NOT NULL,
+	expire    TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (namespace, peer_id)
+);
+CREATE INDEX IF NOT EXISTS rdvp_registrations_namespace_idx ON rdvp_registrations (namespace);
+`
+
+// postgresStore backs Store with a single `rdvp_registrations` table, for
+// deployments that need to share registration state across a fleet of rdvp
+// processes behind a load balancer.
+type postgresStore struct {
+	db *sql.DB
+}
+
+func openPostgres(ctx context.Context, urn string) (Store, error) {
+	db, err := sql.Open("postgres", urn)
+	if err != nil {
+		return nil, errcode.TODO.Wrap(err)
+	}
+	if _, err := db.ExecContext(ctx, postgresSchema); err != nil {
+		return nil, errcode.TODO.Wrap(err)
+	}
+	return &postgresStore{db: db}, nil
+}
+
+func (s *postgresStore) Register(ns string, pi libp2p_peer.AddrInfo, ttl int) (string, error) {
+	addrs := make([]string, len(pi.Addrs))
+	for i, a := range pi.Addrs {
+		addrs[i] = a.String()
+	}
+	payload, err := json.Marshal(addrs)
+	if err != nil {
+		return "", errcode.TODO.Wrap(err)
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO rdvp_registrations (namespace, peer_id, addrs, expire)
+		VALUES ($1, $2, $3, now() + $4 * interval '1 second')
+		ON CONFLICT (namespace, peer_id) DO UPDATE SET addrs = EXCLUDED.addrs, expire = EXCLUDED.expire
+	`, ns, pi.ID.Pretty(), payload, ttl)
+	if err != nil {
+		return "", errcode.TODO.Wrap(err)
+	}
+
+	return pi.ID.Pretty(), nil
+}
+
+func (s *postgresStore) Unregister(ns string, p libp2p_peer.ID) error {
+	_, err := s.db.Exec(`DELETE FROM rdvp_registrations WHERE namespace = $1 AND peer_id = $2`, ns, p.Pretty())
+	if err != nil {
+		return errcode.TODO.Wrap(err)
+	}
+	return nil
+}
+
+// Discover paginates by peer_id: cookie, when set, is the peer_id of the
+// last registration returned by the previous call, and the query resumes
+// just past it in the same ORDER BY peer_id it was produced under.
+func (s *postgresStore) Discover(ns string, cookie []byte, limit int) ([]libp2p_rp.Registration, []byte, error) {
+	query := `
+		SELECT peer_id, addrs FROM rdvp_registrations
+		WHERE namespace = $1 AND expire > now() AND peer_id > $2
+		ORDER BY peer_id
+	`
+	args := []interface{}{ns, string(cookie)}
+	if limit > 0 {
+		query += " LIMIT $3"
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, nil, errcode.TODO.Wrap(err)
+	}
+	defer rows.Close()
+
+	var regs []libp2p_rp.Registration
+	var newCookie []byte
+	for rows.Next() {
+		var peerID string
+		var rawAddrs []byte
+		if err := rows.Scan(&peerID, &rawAddrs); err != nil {
+			return nil, nil, errcode.TODO.Wrap(err)
+		}
+
+		pid, err := libp2p_peer.Decode(peerID)
+		if err != nil {
+			continue
+		}
+
+		var addrStrs []string
+		if err := json.Unmarshal(rawAddrs, &addrStrs); err != nil {
+			continue
+		}
+
+		addrs := make([]ma.Multiaddr, 0, len(addrStrs))
+		for _, a := range addrStrs {
+			if maddr, err := ma.NewMultiaddr(a); err == nil {
+				addrs = append(addrs, maddr)
+			}
+		}
+
+		regs = append(regs, libp2p_rp.Registration{Ns: ns, Peer: libp2p_peer.AddrInfo{ID: pid, Addrs: addrs}})
+		newCookie = []byte(peerID)
+	}
+
+	return regs, newCookie, rows.Err()
+}
+
+func (s *postgresStore) Cleanup() {
+	_, _ = s.db.Exec(`DELETE FROM rdvp_registrations WHERE expire <= now()`)
+}
+
+func (s *postgresStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *postgresStore) GC(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM rdvp_registrations WHERE expire <= now()`)
+	if err != nil {
+		return errcode.TODO.Wrap(err)
+	}
+	return nil
+}
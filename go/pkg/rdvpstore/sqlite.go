@@ -0,0 +1,29 @@
+package rdvpstore
+
+import (
+	"context"
+
+	libp2p_rpdb "github.com/libp2p/go-libp2p-rendezvous/db/sqlite"
+)
+
+func init() {
+	Register("sqlite", openSqlite)
+}
+
+// sqliteStore adapts the go-libp2p-rendezvous sqlite DB to Store.
+type sqliteStore struct {
+	*libp2p_rpdb.DB
+}
+
+func openSqlite(ctx context.Context, urn string) (Store, error) {
+	db, err := libp2p_rpdb.OpenDB(ctx, urn)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteStore{DB: db}, nil
+}
+
+func (s *sqliteStore) GC(ctx context.Context) error {
+	s.DB.Cleanup()
+	return nil
+}
@@ -0,0 +1,64 @@
+// Package rdvpstore defines the persistence contract rdvp's rendezvous
+// service is built on, plus a registry of drivers (sqlite, badger, postgres)
+// selectable at runtime via rdvp serve's `-db-driver` flag. Sqlite remains
+// the default for local/dev use; badger and postgres let a deployment scale
+// a single rdvp instance's registration storage horizontally.
+package rdvpstore
+
+import (
+	"context"
+	"fmt"
+
+	libp2p_rp "github.com/libp2p/go-libp2p-rendezvous"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// Store is the persistence contract the rendezvous service is built on.
+// Implementations back Register/Unregister/Discover with a concrete
+// database and are responsible for expiring stale registrations, either
+// eagerly via GC or lazily on read.
+type Store interface {
+	libp2p_rp.DB
+
+	// GC removes expired registrations. Drivers that already expire lazily
+	// on read (e.g. badger TTLs) may make this a no-op.
+	GC(ctx context.Context) error
+
+	// Close releases the underlying database handle.
+	Close() error
+}
+
+// Driver opens a Store from a driver-specific URN (a file path for
+// sqlite/badger, a DSN for postgres).
+type Driver func(ctx context.Context, urn string) (Store, error)
+
+var drivers = map[string]Driver{}
+
+// Register adds a driver under name, to be selected via `-db-driver name`.
+// Drivers call this from an init() func, following database/sql's
+// convention. It panics on duplicate registration.
+func Register(name string, driver Driver) {
+	if _, ok := drivers[name]; ok {
+		panic(fmt.Sprintf("rdvpstore: driver %q already registered", name))
+	}
+	drivers[name] = driver
+}
+
+// Open opens the named driver's Store with the given URN.
+func Open(ctx context.Context, name, urn string) (Store, error) {
+	driver, ok := drivers[name]
+	if !ok {
+		return nil, errcode.TODO.Wrap(fmt.Errorf("rdvpstore: unknown driver %q (available: %v)", name, Drivers()))
+	}
+	return driver(ctx, urn)
+}
+
+// Drivers returns the names of all registered drivers.
+func Drivers() []string {
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,123 @@
+package rdvpstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	libp2p_peer "github.com/libp2p/go-libp2p-core/peer"
+	libp2p_test "github.com/libp2p/go-libp2p-core/test"
+)
+
+// testStoreConformance exercises the Store contract a driver must satisfy,
+// independent of which backend it's running against.
+func testStoreConformance(t *testing.T, store Store) {
+	t.Helper()
+	ctx := context.Background()
+
+	// drivers decode stored peer IDs on Discover, so these must be real
+	// multihash-based IDs rather than arbitrary strings.
+	var peers [3]libp2p_peer.ID
+	for i := range peers {
+		p, err := libp2p_test.RandPeerID()
+		if err != nil {
+			t.Fatalf("RandPeerID: %v", err)
+		}
+		peers[i] = p
+	}
+	peer1, peer2, peer3 := peers[0], peers[1], peers[2]
+
+	for _, p := range []libp2p_peer.ID{peer1, peer2, peer3} {
+		if _, err := store.Register("ns", libp2p_peer.AddrInfo{ID: p}, 60); err != nil {
+			t.Fatalf("Register(%s): %v", p, err)
+		}
+	}
+
+	regs, _, err := store.Discover("ns", nil, 0)
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	if len(regs) != 3 {
+		t.Fatalf("expected 3 registrations, got %d", len(regs))
+	}
+
+	if err := store.Unregister("ns", peer2); err != nil {
+		t.Fatalf("Unregister: %v", err)
+	}
+	regs, _, err = store.Discover("ns", nil, 0)
+	if err != nil {
+		t.Fatalf("Discover after Unregister: %v", err)
+	}
+	if len(regs) != 2 {
+		t.Fatalf("expected 2 registrations after Unregister, got %d", len(regs))
+	}
+	for _, reg := range regs {
+		if reg.Peer.ID == peer2 {
+			t.Fatalf("unregistered peer %s still present in Discover results", peer2)
+		}
+	}
+
+	// paginate one page at a time and make sure every registration is seen
+	// exactly once, which is what a cookie-based Discover must guarantee.
+	seen := map[libp2p_peer.ID]bool{}
+	var cookie []byte
+	for {
+		page, next, err := store.Discover("ns", cookie, 1)
+		if err != nil {
+			t.Fatalf("Discover(page): %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, reg := range page {
+			if seen[reg.Peer.ID] {
+				t.Fatalf("peer %s returned more than once across paginated Discover calls", reg.Peer.ID)
+			}
+			seen[reg.Peer.ID] = true
+		}
+		cookie = next
+	}
+	if len(seen) != 2 {
+		t.Fatalf("pagination should have visited 2 registrations, saw %d", len(seen))
+	}
+
+	if err := store.GC(ctx); err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+}
+
+func TestSqliteStoreConformance(t *testing.T) {
+	store, err := Open(context.Background(), "sqlite", filepath.Join(t.TempDir(), "rdvp.sqlite"))
+	if err != nil {
+		t.Fatalf("Open(sqlite): %v", err)
+	}
+	defer store.Close()
+
+	testStoreConformance(t, store)
+}
+
+func TestBadgerStoreConformance(t *testing.T) {
+	store, err := Open(context.Background(), "badger", t.TempDir())
+	if err != nil {
+		t.Fatalf("Open(badger): %v", err)
+	}
+	defer store.Close()
+
+	testStoreConformance(t, store)
+}
+
+func TestPostgresStoreConformance(t *testing.T) {
+	dsn := os.Getenv("RDVP_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("RDVP_TEST_POSTGRES_DSN not set, skipping postgres conformance test")
+	}
+
+	store, err := Open(context.Background(), "postgres", dsn)
+	if err != nil {
+		t.Fatalf("Open(postgres): %v", err)
+	}
+	defer store.Close()
+
+	testStoreConformance(t, store)
+}